@@ -0,0 +1,193 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// AWSKMSConfig represents the configuration for an AWS KMS backend.
+type AWSKMSConfig struct {
+	Endpoint string `json:"endpoint"` // Optional custom AWS KMS endpoint, e.g. a VPC endpoint
+	Region   string `json:"region"`   // The AWS region the customer master key lives in
+	KeyID    string `json:"key-id"`   // The ID or ARN of the customer master key (CMK)
+	// AccessKey and SecretKey are intentionally not part of the config
+	// file - like every other AWS SDK client, credentials are resolved
+	// from the environment, the shared credentials file or an
+	// instance/task role, never stored in plaintext config.
+}
+
+var emptyAWSKMSConfig = AWSKMSConfig{}
+
+// IsEmpty returns true if the AWS KMS config struct is an empty
+// configuration.
+func (c *AWSKMSConfig) IsEmpty() bool { return *c == emptyAWSKMSConfig }
+
+// Verify returns a nil error if the AWS KMS configuration is valid. A
+// valid configuration is either empty or contains valid non-default
+// values.
+func (c *AWSKMSConfig) Verify() error {
+	if c.IsEmpty() {
+		return nil
+	}
+	switch {
+	case c.Region == "":
+		return errors.New("crypto: missing AWS KMS region")
+	case c.KeyID == "":
+		return errors.New("crypto: missing AWS KMS key ID")
+	}
+	return nil
+}
+
+// awsKMS implements the KMS interface using AWS Key Management
+// Service's GenerateDataKey, Decrypt and ReEncrypt APIs.
+type awsKMS struct {
+	config *AWSKMSConfig
+	client *kms.KMS
+}
+
+var _ KMS = (*awsKMS)(nil) // compiler check that *awsKMS implements KMS
+
+// NewAWSKMS initializes a KMS backed by AWS Key Management Service.
+// AWS credentials are resolved the same way the AWS SDK resolves them
+// for any other client - environment, shared credentials file, then
+// instance/task role.
+func NewAWSKMS(config AWSKMSConfig) (KMS, error) {
+	if config.IsEmpty() {
+		return nil, errors.New("crypto: the AWS KMS configuration must not be empty")
+	}
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+
+	awsCfg := aws.NewConfig().WithRegion(config.Region)
+	if config.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(config.Endpoint)
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &awsKMS{config: &config, client: kms.New(sess)}, nil
+}
+
+// awsEncryptionContext turns ctx into the string map AWS KMS expects as
+// its encryption context / AAD.
+func awsEncryptionContext(ctx Context) map[string]*string {
+	var buf bytes.Buffer
+	ctx.WriteTo(&buf)
+	return map[string]*string{"minio-context": aws.String(buf.String())}
+}
+
+// GenerateKey asks AWS KMS to generate a new 256-bit data-encryption-
+// key under the configured CMK, bound to ctx as the encryption
+// context.
+func (a *awsKMS) GenerateKey(keyID string, ctx Context) (key [32]byte, sealedKey []byte, err error) {
+	if keyID == "" {
+		keyID = a.config.KeyID
+	}
+	out, err := a.client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           aws.String("AES_256"),
+		EncryptionContext: awsEncryptionContext(ctx),
+	})
+	if err != nil {
+		return key, sealedKey, err
+	}
+	copy(key[:], out.Plaintext)
+	return key, sealEnvelope(sealedKeyBackendAWS, out.CiphertextBlob), nil
+}
+
+// UnsealKey asks AWS KMS to decrypt sealedKey back into the
+// plaintext data-encryption-key.
+func (a *awsKMS) UnsealKey(keyID string, sealedKey []byte, ctx Context) (key [32]byte, err error) {
+	_, ciphertext, err := openEnvelope(sealedKey)
+	if err != nil {
+		return key, err
+	}
+	out, err := a.client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: awsEncryptionContext(ctx),
+	})
+	if err != nil {
+		return key, err
+	}
+	copy(key[:], out.Plaintext)
+	return key, nil
+}
+
+// SealKey seals an already-generated plaintext data-key using AWS
+// KMS's Encrypt API instead of asking it to generate a new one. It is
+// used to move an existing data-key to AWS KMS from a different KMS
+// backend during a migration, without re-encrypting the object the
+// key protects.
+func (a *awsKMS) SealKey(keyID string, key [32]byte, ctx Context) (sealedKey []byte, err error) {
+	if keyID == "" {
+		keyID = a.config.KeyID
+	}
+	out, err := a.client.Encrypt(&kms.EncryptInput{
+		KeyId:             aws.String(keyID),
+		Plaintext:         key[:],
+		EncryptionContext: awsEncryptionContext(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelope(sealedKeyBackendAWS, out.CiphertextBlob), nil
+}
+
+var _ KeySealer = (*awsKMS)(nil) // compiler check that *awsKMS implements KeySealer
+
+// RewrapKey re-encrypts sealedKey under the configured CMK's current
+// key material using AWS KMS's ReEncrypt API, without ever exposing
+// the plaintext data-encryption-key.
+func (a *awsKMS) RewrapKey(keyID string, sealedKey []byte, ctx Context) ([]byte, error) {
+	if keyID == "" {
+		keyID = a.config.KeyID
+	}
+	_, ciphertext, err := openEnvelope(sealedKey)
+	if err != nil {
+		return nil, err
+	}
+	encCtx := awsEncryptionContext(ctx)
+	out, err := a.client.ReEncrypt(&kms.ReEncryptInput{
+		CiphertextBlob:               ciphertext,
+		SourceEncryptionContext:      encCtx,
+		DestinationKeyId:             aws.String(keyID),
+		DestinationEncryptionContext: encCtx,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelope(sealedKeyBackendAWS, out.CiphertextBlob), nil
+}
+
+// Status reports AWS KMS as up as long as its CMK is reachable and
+// enabled. AWS KMS requests are synchronous and stateless, so there
+// is no background session to track the way Vault has.
+func (a *awsKMS) Status() KMSStatus {
+	keyID := a.config.KeyID
+	out, err := a.client.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil || out.KeyMetadata == nil || aws.StringValue(out.KeyMetadata.KeyState) != "Enabled" {
+		return KMSStatus{Up: false}
+	}
+	return KMSStatus{Up: true, LastRenewal: time.Now().UTC()}
+}
@@ -0,0 +1,53 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"testing"
+	"time"
+)
+
+// jitterBounds returns the [lo, hi] range next() can return for an
+// unjittered duration d, given the ±20% jitter it applies.
+func jitterBounds(d time.Duration) (lo, hi time.Duration) {
+	return d - d/5, d + d/5
+}
+
+func TestBackoffNextDoublesUntilCap(t *testing.T) {
+	base, max := time.Second, 8*time.Second
+	b := newBackoff(base, max)
+
+	wantUncapped := []time.Duration{base, 2 * base, 4 * base, max, max}
+	for i, want := range wantUncapped {
+		got := b.next()
+		lo, hi := jitterBounds(want)
+		if got < lo || got > hi {
+			t.Errorf("next() #%d = %v, want within ±20%% of %v (allowed [%v, %v])", i, got, want, lo, hi)
+		}
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := newBackoff(time.Second, time.Minute)
+	b.next()
+	b.next()
+	b.reset()
+
+	lo, hi := jitterBounds(time.Second)
+	got := b.next()
+	if got < lo || got > hi {
+		t.Errorf("next() after reset = %v, want within ±20%% of %v", got, time.Second)
+	}
+}
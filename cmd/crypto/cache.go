@@ -0,0 +1,394 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheConfig configures the data-key cache a KMS can optionally be
+// wrapped with. It is disabled by default - operators opt in once
+// Vault's (or any other KMS backend's) transit QPS becomes the
+// bottleneck on hot buckets.
+type CacheConfig struct {
+	Enabled bool          `json:"enabled"` // Whether the cache is active at all
+	Size    int           `json:"size"`    // The maximum number of entries kept per cache, 0 means use the default
+	TTL     time.Duration `json:"ttl"`     // How long an unsealed data-key stays cached, 0 means use the default
+}
+
+const (
+	defaultCacheSize = 10000
+	defaultCacheTTL  = 10 * time.Minute
+
+	// generateCacheTTL is intentionally very short - reusing a
+	// generated data-key for too long would weaken the binding
+	// between an object and its own unique key.
+	generateCacheTTL = 5 * time.Second
+)
+
+// CacheInvalidator is implemented by KMS wrappers that cache
+// unsealed data-keys and therefore need to be told when a key was
+// rotated so they stop serving plaintexts sealed under a retired
+// version.
+type CacheInvalidator interface {
+	// InvalidateCache evicts every cached entry for keyID.
+	InvalidateCache(keyID string)
+}
+
+// KeyRotationNotifier is implemented by KMS backends that can notify
+// interested parties when a master key's version changes, e.g. a
+// Vault transit key background sweeper.
+type KeyRotationNotifier interface {
+	// OnKeyRotated registers fn to be called with the key's name
+	// whenever its current version changes. Multiple callbacks can be
+	// registered; all of them are invoked.
+	OnKeyRotated(fn func(keyID string))
+}
+
+// cacheMetrics tracks cache hit/miss counts for the Prometheus
+// KMS cache metric.
+type cacheMetrics struct {
+	hits   uint64
+	misses uint64
+}
+
+func (m *cacheMetrics) hit()  { atomic.AddUint64(&m.hits, 1) }
+func (m *cacheMetrics) miss() { atomic.AddUint64(&m.misses, 1) }
+
+// CacheStats returns the current hit/miss counters.
+func (m *cacheMetrics) CacheStats() (hits, misses uint64) {
+	return atomic.LoadUint64(&m.hits), atomic.LoadUint64(&m.misses)
+}
+
+// generatedKey is a data-key together with its sealed form, the unit
+// cached by the generate cache.
+type generatedKey struct {
+	key       [32]byte
+	sealedKey []byte
+}
+
+// cachingKMS decorates a KMS with bounded, TTL'd LRU caches so that
+// repeated GET/PUT of the same object (or objects sharing a bucket
+// default key) do not each round-trip to the KMS. It is disabled by
+// default - construct it only if CacheConfig.Enabled is set.
+type cachingKMS struct {
+	kms KMS
+
+	unseal   *lruCache // cacheKey -> [32]byte
+	generate *lruCache // cacheKey -> generatedKey
+
+	group   singleflight.Group
+	metrics cacheMetrics
+}
+
+var _ KMS = (*cachingKMS)(nil)                 // compiler check that *cachingKMS implements KMS
+var _ CacheInvalidator = (*cachingKMS)(nil)    // compiler check that *cachingKMS implements CacheInvalidator
+var _ RewrapChecker = (*cachingKMS)(nil)       // compiler check that *cachingKMS implements RewrapChecker
+var _ KeySealer = (*cachingKMS)(nil)           // compiler check that *cachingKMS implements KeySealer
+var _ KeyRotationNotifier = (*cachingKMS)(nil) // compiler check that *cachingKMS implements KeyRotationNotifier
+
+// NewCachingKMS wraps kms with an unseal-key cache and, for a very
+// short window, a generate-key cache. If cfg is not Enabled, kms is
+// returned unchanged. If kms implements KeyRotationNotifier, the
+// cache registers itself so rotated keys are evicted as soon as the
+// rotation is observed.
+func NewCachingKMS(kms KMS, cfg CacheConfig) KMS {
+	if !cfg.Enabled {
+		return kms
+	}
+	size := cfg.Size
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	c := &cachingKMS{
+		kms:      kms,
+		unseal:   newLRUCache(size, ttl),
+		generate: newLRUCache(size, generateCacheTTL),
+	}
+	if notifier, ok := kms.(KeyRotationNotifier); ok {
+		notifier.OnKeyRotated(c.InvalidateCache)
+	}
+	return c
+}
+
+// CacheStats returns the cache hit/miss counters, mainly for the
+// Prometheus KMS cache metric.
+func (c *cachingKMS) CacheStats() (hits, misses uint64) { return c.metrics.CacheStats() }
+
+// InvalidateCache evicts every cached entry for keyID from both the
+// unseal and the generate cache.
+func (c *cachingKMS) InvalidateCache(keyID string) {
+	c.unseal.evictKey(keyID)
+	c.generate.evictKey(keyID)
+}
+
+// GenerateKey generates a new data-encryption-key, reusing a recently
+// generated one for the exact same (keyID, context) pair within the
+// very short generate-cache window instead of always calling the
+// underlying KMS.
+func (c *cachingKMS) GenerateKey(keyID string, ctx Context) (key [32]byte, sealedKey []byte, err error) {
+	cacheKey := dataKeyCacheKey(keyID, ctx, nil)
+	if v, ok := c.generate.get(cacheKey); ok {
+		c.metrics.hit()
+		gk := v.(*generatedKey)
+		return gk.key, gk.sealedKey, nil
+	}
+	c.metrics.miss()
+
+	v, err, _ := c.group.Do("generate:"+cacheKey, func() (interface{}, error) {
+		key, sealedKey, err := c.kms.GenerateKey(keyID, ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Cache its own copy so a subsequent eviction's zeroize can
+		// never race with the value returned below.
+		cached := key
+		c.generate.put(cacheKey, keyID, &generatedKey{key: cached, sealedKey: sealedKey})
+		unsealedKey := key
+		c.unseal.put(dataKeyCacheKey(keyID, ctx, sealedKey), keyID, &unsealedKey)
+		return generatedKey{key: key, sealedKey: sealedKey}, nil
+	})
+	if err != nil {
+		return key, sealedKey, err
+	}
+	gk := v.(generatedKey)
+	return gk.key, gk.sealedKey, nil
+}
+
+// UnsealKey unseals sealedKey, serving a cached plaintext for the
+// exact same (keyID, context, sealedKey) triple when available and
+// coalescing concurrent misses for the same triple into a single
+// KMS round-trip.
+func (c *cachingKMS) UnsealKey(keyID string, sealedKey []byte, ctx Context) (key [32]byte, err error) {
+	cacheKey := dataKeyCacheKey(keyID, ctx, sealedKey)
+	if v, ok := c.unseal.get(cacheKey); ok {
+		c.metrics.hit()
+		return *v.(*[32]byte), nil
+	}
+	c.metrics.miss()
+
+	v, err, _ := c.group.Do("unseal:"+cacheKey, func() (interface{}, error) {
+		key, err := c.kms.UnsealKey(keyID, sealedKey, ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Cache its own copy so a subsequent eviction's zeroize can
+		// never race with the value returned below.
+		cached := key
+		c.unseal.put(cacheKey, keyID, &cached)
+		return key, nil
+	})
+	if err != nil {
+		return key, err
+	}
+	return v.([32]byte), nil
+}
+
+// RewrapKey always goes straight to the underlying KMS - a rewrap is
+// already a deliberate, infrequent maintenance operation, so there is
+// nothing useful to cache.
+func (c *cachingKMS) RewrapKey(keyID string, sealedKey []byte, ctx Context) ([]byte, error) {
+	return c.kms.RewrapKey(keyID, sealedKey, ctx)
+}
+
+// Status delegates to the underlying KMS.
+func (c *cachingKMS) Status() KMSStatus { return c.kms.Status() }
+
+// NeedsRewrap delegates to the underlying KMS's RewrapChecker
+// implementation, if it has one, so wrapping a KMS in a cache does
+// not hide the capability from callers that type-assert for it. It
+// reports false if the underlying KMS does not support the check.
+func (c *cachingKMS) NeedsRewrap(keyID string, sealedKey []byte) (bool, error) {
+	checker, ok := c.kms.(RewrapChecker)
+	if !ok {
+		return false, nil
+	}
+	return checker.NeedsRewrap(keyID, sealedKey)
+}
+
+// SealKey delegates to the underlying KMS's KeySealer implementation,
+// if it has one, so wrapping a KMS in a cache does not hide the
+// capability from callers - e.g. MigrateKMS - that type-assert for
+// it. Sealing bypasses both caches; it is only ever used by the
+// offline migration tool, never the request path.
+func (c *cachingKMS) SealKey(keyID string, key [32]byte, ctx Context) ([]byte, error) {
+	sealer, ok := c.kms.(KeySealer)
+	if !ok {
+		return nil, errors.New("crypto: this KMS backend does not support sealing an existing key")
+	}
+	return sealer.SealKey(keyID, key, ctx)
+}
+
+// OnKeyRotated registers fn to be called, alongside the cache's own
+// invalidation, whenever the underlying KMS reports a key rotation.
+// It is a no-op if the underlying KMS does not support rotation
+// notifications.
+func (c *cachingKMS) OnKeyRotated(fn func(keyID string)) {
+	if notifier, ok := c.kms.(KeyRotationNotifier); ok {
+		notifier.OnKeyRotated(fn)
+	}
+}
+
+// dataKeyCacheKey canonicalizes (keyID, context, sealedKey) into a
+// single cache key. The context is serialized the same way it is
+// bound into the KMS request, and the sealedKey is hashed rather than
+// used verbatim since ciphertexts can be arbitrarily large.
+func dataKeyCacheKey(keyID string, ctx Context, sealedKey []byte) string {
+	var buf bytes.Buffer
+	ctx.WriteTo(&buf)
+
+	h := sha256.New()
+	h.Write([]byte(keyID))
+	h.Write(buf.Bytes())
+	h.Write(sealedKey)
+	return string(h.Sum(nil))
+}
+
+// lruEntry is one bounded-LRU slot. value is either a *[32]byte (the
+// unseal cache) or a *generatedKey (the generate cache) - zeroizeValue
+// knows how to wipe both.
+type lruEntry struct {
+	cacheKey string
+	keyID    string
+	value    interface{}
+	expires  time.Time
+}
+
+// lruCache is a bounded, TTL'd LRU cache used for both the unseal and
+// the generate data-key caches. Entries are zeroized when evicted so
+// plaintext key material does not linger in memory longer than
+// necessary.
+type lruCache struct {
+	mu       sync.Mutex
+	size     int
+	ttl      time.Duration
+	ll       *list.List
+	elements map[string]*list.Element
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:     size,
+		ttl:      ttl,
+		ll:       list.New(),
+		elements: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lruCache) get(cacheKey string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.elements[cacheKey]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElementLocked(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return cloneValue(entry.value), true
+}
+
+func (c *lruCache) put(cacheKey, keyID string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.elements[cacheKey]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expires = time.Now().Add(c.ttl)
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	entry := &lruEntry{cacheKey: cacheKey, keyID: keyID, value: value, expires: time.Now().Add(c.ttl)}
+	elem := c.ll.PushFront(entry)
+	c.elements[cacheKey] = elem
+
+	for c.ll.Len() > c.size {
+		c.removeElementLocked(c.ll.Back())
+	}
+}
+
+// evictKey removes every cached entry whose keyID matches - used
+// when a master key is rotated. Since entries are indexed by a
+// canonical hash rather than keyID, this walks the whole cache; it is
+// only called on the rare rotation event, not on the request path.
+func (c *lruCache) evictKey(keyID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range c.elements {
+		if elem.Value.(*lruEntry).keyID == keyID {
+			c.removeElementLocked(elem)
+		}
+	}
+}
+
+// removeElementLocked must be called with c.mu held.
+func (c *lruCache) removeElementLocked(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	delete(c.elements, entry.cacheKey)
+	c.ll.Remove(elem)
+	zeroizeValue(entry.value)
+}
+
+// zeroizeValue overwrites the key material held by value with zeros
+// in a way the compiler cannot optimize away. value must be one of
+// the pointer types lruCache stores (*[32]byte or *generatedKey) so
+// the zeroing reaches the actual cached memory, not a copy of it.
+func zeroizeValue(value interface{}) {
+	switch v := value.(type) {
+	case *[32]byte:
+		subtle.ConstantTimeCopy(1, v[:], make([]byte, 32))
+	case *generatedKey:
+		subtle.ConstantTimeCopy(1, v.key[:], make([]byte, 32))
+	}
+}
+
+// cloneValue copies the key material held by value into a fresh,
+// cache-independent copy. get() returns the clone, never the
+// cache-owned pointer, so a concurrent eviction is free to zeroize the
+// original without racing with a caller still reading what get()
+// handed back.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *[32]byte:
+		clone := *v
+		return &clone
+	case *generatedKey:
+		clone := *v
+		return &clone
+	default:
+		return value
+	}
+}
@@ -0,0 +1,141 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheGetPut(t *testing.T) {
+	c := newLRUCache(10, time.Minute)
+	key := &[32]byte{1, 2, 3}
+	c.put("a", "key-a", key)
+
+	v, ok := c.get("a")
+	if !ok {
+		t.Fatal("expected a cache hit")
+	}
+	if *v.(*[32]byte) != *key {
+		t.Errorf("got %v, want %v", *v.(*[32]byte), *key)
+	}
+
+	if _, ok := c.get("missing"); ok {
+		t.Error("expected a cache miss for an absent key")
+	}
+}
+
+func TestLRUCacheGetReturnsAClone(t *testing.T) {
+	c := newLRUCache(10, time.Minute)
+	original := &[32]byte{1, 2, 3}
+	c.put("a", "key-a", original)
+
+	v, ok := c.get("a")
+	if !ok {
+		t.Fatal("expected a cache hit")
+	}
+	clone := v.(*[32]byte)
+	if clone == original {
+		t.Fatal("get() must return a copy, not the cache-owned pointer")
+	}
+
+	// Zeroizing the clone must not affect the value still held by the
+	// cache - they must not share backing memory.
+	zeroizeValue(clone)
+	v2, ok := c.get("a")
+	if !ok {
+		t.Fatal("expected a second cache hit")
+	}
+	if *v2.(*[32]byte) != *original {
+		t.Error("zeroizing a value returned by get() corrupted the cached entry")
+	}
+}
+
+func TestLRUCacheTTLExpiry(t *testing.T) {
+	c := newLRUCache(10, 10*time.Millisecond)
+	c.put("a", "key-a", &[32]byte{1})
+
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := c.get("a"); ok {
+		t.Error("expected the entry to have expired")
+	}
+}
+
+func TestLRUCacheCapacityEviction(t *testing.T) {
+	c := newLRUCache(1, time.Minute)
+	c.put("a", "key-a", &[32]byte{1})
+	c.put("b", "key-b", &[32]byte{2})
+
+	if _, ok := c.get("a"); ok {
+		t.Error("expected \"a\" to have been evicted once the cache exceeded its capacity")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Error("expected \"b\" to still be cached")
+	}
+}
+
+func TestLRUCacheEvictKeyZeroizes(t *testing.T) {
+	c := newLRUCache(10, time.Minute)
+	key := &[32]byte{1, 2, 3, 4}
+	c.put("a", "key-a", key)
+
+	c.evictKey("key-a")
+
+	if _, ok := c.get("a"); ok {
+		t.Error("expected the entry to have been evicted")
+	}
+	if *key != ([32]byte{}) {
+		t.Error("expected the evicted key's backing array to have been zeroized")
+	}
+}
+
+// TestLRUCacheConcurrentAccessRace hammers get/put/evictKey on a tiny
+// cache from many goroutines at once. Run with -race: a get() that
+// ever returns a pointer still owned by the cache would race against
+// a concurrent eviction's zeroize of that same memory.
+func TestLRUCacheConcurrentAccessRace(t *testing.T) {
+	c := newLRUCache(1, time.Minute)
+	want := [32]byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	var wg sync.WaitGroup
+	stop := time.After(100 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := want
+				c.put("shared", "shared-key", &key)
+				// Every goroutine ever puts the same byte pattern, so
+				// a hit must return exactly that pattern - never a
+				// value caught mid-zeroize by a concurrent eviction.
+				if v, ok := c.get("shared"); ok {
+					if got := *v.(*[32]byte); got != want {
+						t.Errorf("get() returned a partially-zeroed key: %v", got)
+					}
+				}
+				c.evictKey("shared-key")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,120 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KMSConfig selects and configures the KMS backend(s) a server uses:
+// Vault, AWS KMS, GCP KMS and/or Azure Key Vault. Ordinarily exactly
+// one of the backend blocks is non-empty. During a `--migrate-kms`
+// cutover, operators may configure the new backend alongside the one
+// being retired; Active then names which one seals new keys, while
+// the other stays live for decrypt/rewrap of keys it sealed earlier.
+type KMSConfig struct {
+	Active string         `json:"active"` // Which of vault/aws/gcp/azure seals new keys; inferred if only one is configured
+	Vault  VaultConfig    `json:"vault"`
+	AWS    AWSKMSConfig   `json:"aws"`
+	GCP    GCPKMSConfig   `json:"gcp"`
+	Azure  AzureKMSConfig `json:"azure"`
+	Cache  CacheConfig    `json:"cache"`
+}
+
+// kmsBackend names one of the backend blocks in KMSConfig together
+// with how to tell it is configured, how to construct it, and the
+// envelope tag sealed keys produced by it carry.
+type kmsBackend struct {
+	name  string
+	tag   sealedKeyBackend
+	empty bool
+	new   func() (KMS, error)
+}
+
+// namedBackends returns cfg's backend blocks together with the name a
+// `kms.active` value or a `--migrate-kms from=.../to=...` argument
+// uses to refer to them.
+func namedBackends(cfg KMSConfig) []kmsBackend {
+	return []kmsBackend{
+		{"vault", sealedKeyBackendVault, cfg.Vault.IsEmpty(), func() (KMS, error) { return NewVault(cfg.Vault) }},
+		{"aws", sealedKeyBackendAWS, cfg.AWS.IsEmpty(), func() (KMS, error) { return NewAWSKMS(cfg.AWS) }},
+		{"gcp", sealedKeyBackendGCP, cfg.GCP.IsEmpty(), func() (KMS, error) { return NewGCPKMS(cfg.GCP) }},
+		{"azure", sealedKeyBackendAzure, cfg.Azure.IsEmpty(), func() (KMS, error) { return NewAzureKeyVault(cfg.Azure) }},
+	}
+}
+
+// namedBackend constructs the single backend cfg configures for name
+// ("vault", "aws", "gcp" or "azure"), without the caching wrapper or
+// the multi-backend selection NewKMS applies - used by the
+// `--migrate-kms` command, which always talks to two raw backends
+// directly.
+func namedBackend(cfg KMSConfig, name string) (KMS, error) {
+	for _, b := range namedBackends(cfg) {
+		if b.name != name {
+			continue
+		}
+		if b.empty {
+			return nil, fmt.Errorf("crypto: KMS backend %q is not configured", name)
+		}
+		return b.new()
+	}
+	return nil, fmt.Errorf("crypto: unknown KMS backend %q - must be one of vault, aws, gcp or azure", name)
+}
+
+// NewKMS constructs the KMS backend(s) selected by cfg, optionally
+// wrapped with a data-key cache. With a single backend configured it
+// returns that backend directly; with more than one configured (a
+// migration in progress) it returns a multiplexKMS that seals new
+// keys with cfg.Active and still decrypts/rewraps keys sealed by the
+// other configured backend(s) via their envelope tag. It is an error
+// to configure zero backends, or more than one without naming Active.
+func NewKMS(cfg KMSConfig) (KMS, error) {
+	var configured []kmsBackend
+	for _, b := range namedBackends(cfg) {
+		if !b.empty {
+			configured = append(configured, b)
+		}
+	}
+	if len(configured) == 0 {
+		return nil, errors.New("crypto: no KMS backend configured")
+	}
+	if len(configured) > 1 && cfg.Active == "" {
+		return nil, errors.New("crypto: multiple KMS backends configured - kms.active must name which one seals new keys during the migration")
+	}
+
+	built := make(map[sealedKeyBackend]KMS, len(configured))
+	var active KMS
+	var activeTag sealedKeyBackend
+	for _, b := range configured {
+		kms, err := b.new()
+		if err != nil {
+			return nil, err
+		}
+		built[b.tag] = kms
+		if len(configured) == 1 || b.name == cfg.Active {
+			active, activeTag = kms, b.tag
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("crypto: kms.active %q does not match any configured backend", cfg.Active)
+	}
+
+	kms := active
+	if len(configured) > 1 {
+		kms = newMultiplexKMS(active, activeTag, built)
+	}
+	return NewCachingKMS(kms, cfg.Cache), nil
+}
@@ -0,0 +1,63 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"bytes"
+	"errors"
+)
+
+// sealedKeyBackend identifies which KMS backend a sealed key was
+// produced by. It is tagged onto every sealed key so that a server
+// can keep decrypting objects sealed under one backend while it is
+// being migrated to another, e.g. during `--migrate-kms`.
+type sealedKeyBackend byte
+
+const (
+	// sealedKeyBackendVault is never written explicitly: Vault's own
+	// transit ciphertexts are already self-describing ("vault:vN:..."),
+	// so they are recognized by prefix instead of an envelope byte.
+	sealedKeyBackendVault sealedKeyBackend = iota
+	sealedKeyBackendAWS
+	sealedKeyBackendGCP
+	sealedKeyBackendAzure
+)
+
+// vaultCiphertextPrefix is the prefix every Vault transit ciphertext
+// starts with, used to recognize un-enveloped Vault sealed keys.
+const vaultCiphertextPrefix = "vault:"
+
+// sealEnvelope tags ciphertext with backend so a sealed key can later
+// be routed back to the KMS backend that can unseal it.
+func sealEnvelope(backend sealedKeyBackend, ciphertext []byte) []byte {
+	sealedKey := make([]byte, 0, len(ciphertext)+1)
+	sealedKey = append(sealedKey, byte(backend))
+	sealedKey = append(sealedKey, ciphertext...)
+	return sealedKey
+}
+
+// openEnvelope splits a sealed key into the backend that produced it
+// and the raw ciphertext that backend understands. Vault sealed keys
+// predate the envelope format and are recognized by their own
+// "vault:" prefix instead.
+func openEnvelope(sealedKey []byte) (sealedKeyBackend, []byte, error) {
+	if bytes.HasPrefix(sealedKey, []byte(vaultCiphertextPrefix)) {
+		return sealedKeyBackendVault, sealedKey, nil
+	}
+	if len(sealedKey) < 1 {
+		return 0, nil, errors.New("crypto: sealed key is too short")
+	}
+	return sealedKeyBackend(sealedKey[0]), sealedKey[1:], nil
+}
@@ -0,0 +1,62 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealEnvelopeRoundTrip(t *testing.T) {
+	backends := []sealedKeyBackend{sealedKeyBackendAWS, sealedKeyBackendGCP, sealedKeyBackendAzure}
+	for _, backend := range backends {
+		ciphertext := []byte("some opaque backend ciphertext")
+		sealedKey := sealEnvelope(backend, ciphertext)
+
+		gotBackend, gotCiphertext, err := openEnvelope(sealedKey)
+		if err != nil {
+			t.Fatalf("backend %d: openEnvelope: %v", backend, err)
+		}
+		if gotBackend != backend {
+			t.Errorf("backend %d: got backend %d", backend, gotBackend)
+		}
+		if !bytes.Equal(gotCiphertext, ciphertext) {
+			t.Errorf("backend %d: got ciphertext %q, want %q", backend, gotCiphertext, ciphertext)
+		}
+	}
+}
+
+func TestOpenEnvelopeVaultPrefix(t *testing.T) {
+	// Vault ciphertexts predate the envelope format and are recognized
+	// by their own "vault:" prefix instead of a leading tag byte.
+	sealedKey := []byte("vault:v1:abcdefgh")
+
+	backend, ciphertext, err := openEnvelope(sealedKey)
+	if err != nil {
+		t.Fatalf("openEnvelope: %v", err)
+	}
+	if backend != sealedKeyBackendVault {
+		t.Errorf("got backend %d, want sealedKeyBackendVault", backend)
+	}
+	if !bytes.Equal(ciphertext, sealedKey) {
+		t.Errorf("vault ciphertext must be returned unmodified, got %q", ciphertext)
+	}
+}
+
+func TestOpenEnvelopeTooShort(t *testing.T) {
+	if _, _, err := openEnvelope(nil); err == nil {
+		t.Fatal("expected an error for an empty sealed key")
+	}
+}
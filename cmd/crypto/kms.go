@@ -0,0 +1,138 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+// KMS is a key-management-system that supports generating
+// data-encryption-keys and decrypting them again.
+//
+// A KMS implementation is not required to store the generated
+// data-encryption-keys itself. Instead it returns a plaintext
+// version and a sealed (encrypted) version of the generated key.
+// The server stores only the sealed version and asks the KMS to
+// unseal it whenever the plaintext key is needed again.
+type KMS interface {
+	// GenerateKey generates a new random data-encryption-key and
+	// returns its plaintext and a sealed version of it. The key is
+	// sealed using the master key referenced by keyID and bound to
+	// the given context.
+	GenerateKey(keyID string, context Context) (key [32]byte, sealedKey []byte, err error)
+
+	// UnsealKey unseals the sealedKey using the master key referenced
+	// by keyID and returns the plaintext data-encryption-key. The
+	// context must be the same context that was used to generate the
+	// sealedKey.
+	UnsealKey(keyID string, sealedKey []byte, context Context) (key [32]byte, err error)
+
+	// RewrapKey re-encrypts sealedKey under the current (latest)
+	// master key version referenced by keyID, without ever exposing
+	// the plaintext data-encryption-key to the caller. It is used to
+	// migrate objects sealed under an older master key version
+	// forward after the master key has been rotated.
+	RewrapKey(keyID string, sealedKey []byte, context Context) ([]byte, error)
+
+	// Status reports the current health of the KMS connection, e.g.
+	// when the session was last renewed and how many renewal attempts
+	// have failed since start-up. It is surfaced through the admin API
+	// and Prometheus so operators can see a dying KMS session before
+	// it starts failing object requests.
+	Status() KMSStatus
+}
+
+// KMSStatus describes the current health of a KMS connection.
+type KMSStatus struct {
+	Up            bool          // Whether the KMS is currently reachable and authenticated
+	LastRenewal   time.Time     // The last time the KMS session/lease was successfully renewed
+	RenewalErrors uint64        // The number of renewal/re-login attempts that have failed since start-up
+	LeaseDuration time.Duration // The duration of the current lease, zero if not applicable
+}
+
+// masterKeyKMS implements the KMS interface using a single static
+// master key. It never contacts any external key-management system
+// and is mainly useful for gateways or single-node deployments that
+// do not have access to a real KMS.
+type masterKeyKMS struct {
+	keyID     string
+	masterKey [32]byte
+}
+
+var _ KMS = (*masterKeyKMS)(nil) // compiler check that *masterKeyKMS implements KMS
+
+// NewMasterKey returns a KMS implementation that seals and unseals
+// data-encryption-keys using the provided static master key.
+func NewMasterKey(keyID string, key [32]byte) KMS {
+	return &masterKeyKMS{keyID: keyID, masterKey: key}
+}
+
+// GenerateKey generates a new random data-encryption-key and seals
+// it by XOR-ing it with the static master key - there is no external
+// KMS to delegate this to.
+func (kms *masterKeyKMS) GenerateKey(keyID string, ctx Context) (key [32]byte, sealedKey []byte, err error) {
+	if keyID != "" && keyID != kms.keyID {
+		return key, sealedKey, fmt.Errorf("crypto: key %s does not exist", keyID)
+	}
+	if _, err = io.ReadFull(rand.Reader, key[:]); err != nil {
+		return key, sealedKey, err
+	}
+	sealedKey = make([]byte, 32)
+	for i := range key {
+		sealedKey[i] = key[i] ^ kms.masterKey[i]
+	}
+	return key, sealedKey, nil
+}
+
+// UnsealKey reverses GenerateKey's XOR sealing using the static
+// master key.
+func (kms *masterKeyKMS) UnsealKey(keyID string, sealedKey []byte, ctx Context) (key [32]byte, err error) {
+	if keyID != "" && keyID != kms.keyID {
+		return key, fmt.Errorf("crypto: key %s does not exist", keyID)
+	}
+	if len(sealedKey) != 32 {
+		return key, fmt.Errorf("crypto: invalid sealed key length %d", len(sealedKey))
+	}
+	for i := range key {
+		key[i] = sealedKey[i] ^ kms.masterKey[i]
+	}
+	return key, nil
+}
+
+// RewrapKey is a no-op for masterKeyKMS - there is only ever one
+// master key version, so sealedKey is already current.
+func (kms *masterKeyKMS) RewrapKey(keyID string, sealedKey []byte, ctx Context) ([]byte, error) {
+	return sealedKey, nil
+}
+
+// Status always reports the static master key as up since it
+// requires no network connection or renewal.
+func (kms *masterKeyKMS) Status() KMSStatus {
+	return KMSStatus{Up: true}
+}
+
+// RewrapChecker is implemented by KMS backends that can tell whether
+// a sealedKey was sealed under a master key version that is older
+// than the key's current minimum decryption version. The object
+// layer type-asserts a KMS into this interface so it only pays for a
+// RewrapKey round-trip on objects that actually need it.
+type RewrapChecker interface {
+	// NeedsRewrap reports whether sealedKey should be rewrapped
+	// before its master key version is retired.
+	NeedsRewrap(keyID string, sealedKey []byte) (bool, error)
+}
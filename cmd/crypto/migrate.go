@@ -0,0 +1,121 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySealer is implemented by KMS backends that can seal a plaintext
+// data-key the caller already holds, rather than generating a new
+// random one. It is the operation a cross-backend migration needs:
+// the plaintext key produced by UnsealKey on the source backend must
+// be sealed, unchanged, under the destination backend.
+type KeySealer interface {
+	SealKey(keyID string, key [32]byte, context Context) (sealedKey []byte, err error)
+}
+
+// ObjectKeyMigrator iterates the sealed master keys a MigrateKMS run
+// must rewrap, and persists the replacement sealed key produced for
+// each one. Implementations walk whatever backs bucket/object
+// metadata; MigrateKMS itself has no notion of buckets or objects.
+type ObjectKeyMigrator interface {
+	// ForEachSealedKey calls fn once for every object's sealed master
+	// key under management, stopping at the first error fn returns.
+	ForEachSealedKey(ctx context.Context, fn func(keyID string, sealedKey []byte, objCtx Context) error) error
+
+	// UpdateSealedKey persists sealedKey as the new sealed master key
+	// for keyID, replacing whatever ForEachSealedKey last reported.
+	UpdateSealedKey(ctx context.Context, keyID string, sealedKey []byte, objCtx Context) error
+}
+
+// MigrateKMS rewraps every sealed master key reported by it from the
+// from backend to the to backend: each key is unsealed with from,
+// sealed with to, and the result is persisted via UpdateSealedKey. The
+// plaintext data-key is never written to metadata and is discarded as
+// soon as it has been re-sealed.
+//
+// to must implement KeySealer - it is asked to seal a plaintext key
+// that from generated, not to generate one of its own, so the object
+// data itself never needs to be re-encrypted.
+func MigrateKMS(ctx context.Context, from, to KMS, it ObjectKeyMigrator) error {
+	sealer, ok := to.(KeySealer)
+	if !ok {
+		return errors.New("crypto: destination KMS backend does not support sealing existing keys")
+	}
+
+	return it.ForEachSealedKey(ctx, func(keyID string, sealedKey []byte, objCtx Context) error {
+		key, err := from.UnsealKey(keyID, sealedKey, objCtx)
+		if err != nil {
+			return err
+		}
+		defer zeroizeKey(&key)
+
+		newSealedKey, err := sealer.SealKey(keyID, key, objCtx)
+		if err != nil {
+			return err
+		}
+		return it.UpdateSealedKey(ctx, keyID, newSealedKey, objCtx)
+	})
+}
+
+// ParseMigrateKMSFlag parses the "from=<backend> to=<backend>"
+// argument pairs the `--migrate-kms` admin command takes - e.g.
+// []string{"from=vault", "to=aws"} - into the two backend names.
+func ParseMigrateKMSFlag(args []string) (from, to string, err error) {
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("crypto: invalid --migrate-kms argument %q, want key=value", arg)
+		}
+		switch parts[0] {
+		case "from":
+			from = parts[1]
+		case "to":
+			to = parts[1]
+		default:
+			return "", "", fmt.Errorf("crypto: invalid --migrate-kms argument %q, want from=... or to=...", arg)
+		}
+	}
+	if from == "" || to == "" {
+		return "", "", errors.New("crypto: --migrate-kms requires both from=<backend> and to=<backend>")
+	}
+	return from, to, nil
+}
+
+// RunMigrateKMSCommand implements the `--migrate-kms from=<backend>
+// to=<backend>` admin command: it builds the named source and
+// destination backends directly from cfg - bypassing NewKMS's single
+// active-backend selection, since a migration always talks to two
+// backends at once - and rewraps every sealed master key it reports
+// from the source backend to the destination backend.
+func RunMigrateKMSCommand(ctx context.Context, cfg KMSConfig, args []string, it ObjectKeyMigrator) error {
+	from, to, err := ParseMigrateKMSFlag(args)
+	if err != nil {
+		return err
+	}
+	fromKMS, err := namedBackend(cfg, from)
+	if err != nil {
+		return fmt.Errorf("crypto: --migrate-kms from=%s: %v", from, err)
+	}
+	toKMS, err := namedBackend(cfg, to)
+	if err != nil {
+		return fmt.Errorf("crypto: --migrate-kms to=%s: %v", to, err)
+	}
+	return MigrateKMS(ctx, fromKMS, toKMS, it)
+}
@@ -0,0 +1,114 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import "fmt"
+
+// multiplexKMS routes UnsealKey and RewrapKey to whichever configured
+// backend actually sealed a given key - identified by the tag
+// openEnvelope reports - while GenerateKey, SealKey and Status always
+// go to the single active backend. It exists so a server can keep
+// decrypting objects sealed under a backend that is being retired
+// throughout a `--migrate-kms` run, without ever sealing new keys
+// there once the switch to the new active backend has been made.
+//
+// It is only constructed when more than one backend is configured at
+// once; the ordinary single-backend case never pays for the extra
+// indirection.
+type multiplexKMS struct {
+	active    KMS
+	activeTag sealedKeyBackend
+	backends  map[sealedKeyBackend]KMS
+}
+
+var _ KMS = (*multiplexKMS)(nil) // compiler check that *multiplexKMS implements KMS
+
+// newMultiplexKMS returns a KMS that seals new keys with active and
+// unseals/rewraps existing keys with whichever of backends matches
+// the sealed key's envelope tag.
+func newMultiplexKMS(active KMS, activeTag sealedKeyBackend, backends map[sealedKeyBackend]KMS) KMS {
+	return &multiplexKMS{active: active, activeTag: activeTag, backends: backends}
+}
+
+// GenerateKey always seals new keys with the active backend - the
+// retiring backends are kept around for decrypt-only access.
+func (m *multiplexKMS) GenerateKey(keyID string, ctx Context) (key [32]byte, sealedKey []byte, err error) {
+	return m.active.GenerateKey(keyID, ctx)
+}
+
+// backendFor returns whichever configured backend sealed sealedKey,
+// identified by its envelope tag (or its own "vault:" prefix).
+func (m *multiplexKMS) backendFor(sealedKey []byte) (KMS, error) {
+	tag, _, err := openEnvelope(sealedKey)
+	if err != nil {
+		return nil, err
+	}
+	kms, ok := m.backends[tag]
+	if !ok {
+		return nil, fmt.Errorf("crypto: no configured KMS backend can unseal a key sealed by backend %d - is it still configured during the migration?", tag)
+	}
+	return kms, nil
+}
+
+// UnsealKey unseals sealedKey with the backend that originally sealed
+// it, not necessarily the active one.
+func (m *multiplexKMS) UnsealKey(keyID string, sealedKey []byte, ctx Context) (key [32]byte, err error) {
+	kms, err := m.backendFor(sealedKey)
+	if err != nil {
+		return key, err
+	}
+	return kms.UnsealKey(keyID, sealedKey, ctx)
+}
+
+// RewrapKey rewraps sealedKey with the backend that originally sealed
+// it. It never moves a key to the active backend by itself - that is
+// what MigrateKMS is for.
+func (m *multiplexKMS) RewrapKey(keyID string, sealedKey []byte, ctx Context) ([]byte, error) {
+	kms, err := m.backendFor(sealedKey)
+	if err != nil {
+		return nil, err
+	}
+	return kms.RewrapKey(keyID, sealedKey, ctx)
+}
+
+// Status reports the active backend's status.
+func (m *multiplexKMS) Status() KMSStatus { return m.active.Status() }
+
+// SealKey delegates to the active backend's KeySealer implementation,
+// if it has one.
+func (m *multiplexKMS) SealKey(keyID string, key [32]byte, ctx Context) ([]byte, error) {
+	sealer, ok := m.active.(KeySealer)
+	if !ok {
+		return nil, fmt.Errorf("crypto: the active KMS backend does not support sealing an existing key")
+	}
+	return sealer.SealKey(keyID, key, ctx)
+}
+
+// NeedsRewrap delegates to the sealing backend's RewrapChecker
+// implementation, if it has one.
+func (m *multiplexKMS) NeedsRewrap(keyID string, sealedKey []byte) (bool, error) {
+	kms, err := m.backendFor(sealedKey)
+	if err != nil {
+		return false, err
+	}
+	checker, ok := kms.(RewrapChecker)
+	if !ok {
+		return false, nil
+	}
+	return checker.NeedsRewrap(keyID, sealedKey)
+}
+
+var _ RewrapChecker = (*multiplexKMS)(nil) // compiler check that *multiplexKMS implements RewrapChecker
+var _ KeySealer = (*multiplexKMS)(nil)     // compiler check that *multiplexKMS implements KeySealer
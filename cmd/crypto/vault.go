@@ -18,9 +18,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	vault "github.com/hashicorp/vault/api"
@@ -38,11 +42,18 @@ type VaultKey struct {
 	Version int    `json:"version"` // The key version
 }
 
-// VaultAuth represents vault authentication type.
-// Currently the only supported authentication type is AppRole.
+// VaultAuth represents vault authentication configuration. Type
+// selects which of the credential blocks below is used to log in -
+// the other blocks are ignored.
 type VaultAuth struct {
-	Type    string       `json:"type"`    // The authentication type
-	AppRole VaultAppRole `json:"approle"` // The AppRole authentication credentials
+	Type       string           `json:"type"`       // The authentication type: approle, kubernetes, jwt, cert, aws or token
+	AppRole    VaultAppRole     `json:"approle"`    // The AppRole authentication credentials
+	Kubernetes VaultKubernetes  `json:"kubernetes"` // The Kubernetes service-account authentication settings
+	JWT        VaultJWT         `json:"jwt"`        // The generic JWT/OIDC authentication settings
+	TLS        VaultTLSCertAuth `json:"tls"`        // The mTLS client-certificate authentication settings
+	AWS        VaultAWSIAM      `json:"aws"`        // The AWS IAM authentication settings
+	Token      string           `json:"token"`      // A pre-issued Vault token, used as-is when Type is "token"
+	TokenFile  string           `json:"token_file"` // Path to a file holding a pre-issued Vault token, read on every login when Type is "token"; takes precedence over Token when set
 }
 
 // VaultAppRole represents vault AppRole authentication credentials
@@ -51,20 +62,114 @@ type VaultAppRole struct {
 	Secret string `json:"secret"` // The AppRole secret
 }
 
+// VaultKubernetes represents the settings for Vault's kubernetes auth
+// method.
+type VaultKubernetes struct {
+	Mount   string `json:"mount"`    // The mount path of the kubernetes auth method, defaults to "kubernetes"
+	Role    string `json:"role"`     // The Vault role bound to the service account
+	JWTPath string `json:"jwt-path"` // Path of the service-account JWT, defaults to the well-known in-cluster path
+}
+
+// VaultJWT represents the settings for Vault's generic JWT/OIDC auth
+// method.
+type VaultJWT struct {
+	Mount   string `json:"mount"`    // The mount path of the JWT auth method, defaults to "jwt"
+	Role    string `json:"role"`     // The Vault role bound to the JWT
+	JWT     string `json:"jwt"`      // A static JWT to present at login
+	JWTPath string `json:"jwt-path"` // Path of a JWT file, re-read on every login attempt
+}
+
+// VaultTLSCertAuth represents the settings for Vault's cert auth
+// method. The client certificate and key are taken from CertFile and
+// KeyFile, which NewVault installs on the vault.Client TLS transport.
+type VaultTLSCertAuth struct {
+	Name     string `json:"name"` // The name of the certificate role configured in Vault
+	CertFile string `json:"-"`    // The client certificate used for mTLS, not used in the config file
+	KeyFile  string `json:"-"`    // The client private key used for mTLS, not used in the config file
+}
+
+// VaultAWSIAM represents the settings for Vault's aws auth method
+// using the iam login type.
+type VaultAWSIAM struct {
+	Mount string `json:"mount"` // The mount path of the aws auth method, defaults to "aws"
+	Role  string `json:"role"`  // The Vault role bound to the caller's AWS identity
+}
+
 // VaultConfig represents vault configuration.
 type VaultConfig struct {
-	Endpoint  string    `json:"endpoint"` // The vault API endpoint as URL
-	CAPath    string    `json:"-"`        // The path to PEM-encoded certificate files used for mTLS. Currently not used in config file.
-	Auth      VaultAuth `json:"auth"`     // The vault authentication configuration
-	Key       VaultKey  `json:"key-id"`   // The named key used for key-generation / decryption.
-	Namespace string    `json:"-"`        // The vault namespace of enterprise vault instances
+	Endpoint     string    `json:"endpoint"`      // The vault API endpoint as URL
+	CAPath       string    `json:"-"`             // The path to PEM-encoded certificate files used for mTLS. Currently not used in config file.
+	Auth         VaultAuth `json:"auth"`          // The vault authentication configuration
+	Key          VaultKey  `json:"key-id"`        // The named key used for key-generation / decryption.
+	Namespace    string    `json:"-"`             // The vault namespace of enterprise vault instances
+	TransitMount string    `json:"transit-mount"` // The mount path of the transit secrets engine, defaults to "transit"
 }
 
 // vaultService represents a connection to a vault KMS.
 type vaultService struct {
-	config        *VaultConfig
-	client        *vault.Client
+	config *VaultConfig
+	client *vault.Client
+	auth   VaultAuthMethod
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	statusMu      sync.Mutex
+	up            bool
 	leaseDuration time.Duration
+	lastRenewal   time.Time
+	renewalErrors uint64
+
+	keyVersionsMu sync.RWMutex
+	keyVersions   map[string]vaultKeyVersion
+
+	rotationMu sync.Mutex
+	onRotated  []func(keyID string)
+}
+
+// OnKeyRotated registers fn to be called whenever the background
+// sweeper observes that a transit key's current version changed,
+// e.g. so a cachingKMS wrapper can evict stale plaintexts.
+func (v *vaultService) OnKeyRotated(fn func(keyID string)) {
+	v.rotationMu.Lock()
+	defer v.rotationMu.Unlock()
+	v.onRotated = append(v.onRotated, fn)
+}
+
+var _ KeyRotationNotifier = (*vaultService)(nil) // compiler check that *vaultService implements KeyRotationNotifier
+
+// Close stops the background renewal and key-version sweeper jobs
+// for this vaultService. It must be called to release resources once
+// the KMS is no longer needed.
+func (v *vaultService) Close() error {
+	v.cancel()
+	return nil
+}
+
+// Status returns the current health of the Vault session, updated by
+// the background renewal loop every time it renews or re-logs-in.
+func (v *vaultService) Status() KMSStatus {
+	v.statusMu.Lock()
+	defer v.statusMu.Unlock()
+	return KMSStatus{
+		Up:            v.up,
+		LastRenewal:   v.lastRenewal,
+		RenewalErrors: v.renewalErrors,
+		LeaseDuration: v.leaseDuration,
+	}
+}
+
+// setStatus records the outcome of a renewal or re-login attempt.
+func (v *vaultService) setStatus(up bool, leaseDuration time.Duration) {
+	v.statusMu.Lock()
+	defer v.statusMu.Unlock()
+	v.up = up
+	if up {
+		v.lastRenewal = time.Now().UTC()
+		v.leaseDuration = leaseDuration
+	} else {
+		v.renewalErrors++
+	}
 }
 
 var _ KMS = (*vaultService)(nil) // compiler check that *vaultService implements KMS
@@ -83,15 +188,13 @@ func (v *VaultConfig) Verify() (err error) {
 	if v.IsEmpty() {
 		return // an empty configuration is valid
 	}
+	if v.Endpoint == "" {
+		return errors.New("crypto: missing hashicorp vault endpoint")
+	}
+	if _, err = newVaultAuthMethod(v.Auth); err != nil {
+		return err
+	}
 	switch {
-	case v.Endpoint == "":
-		err = errors.New("crypto: missing hashicorp vault endpoint")
-	case strings.ToLower(v.Auth.Type) != "approle":
-		err = fmt.Errorf("crypto: invalid hashicorp vault authentication type: %s is not supported", v.Auth.Type)
-	case v.Auth.AppRole.ID == "":
-		err = errors.New("crypto: missing hashicorp vault AppRole ID")
-	case v.Auth.AppRole.Secret == "":
-		err = errors.New("crypto: missing hashicorp vault AppSecret ID")
 	case v.Key.Name == "":
 		err = errors.New("crypto: missing hashicorp vault key name")
 	case v.Key.Version < 0:
@@ -112,7 +215,12 @@ func NewVault(config VaultConfig) (KMS, error) {
 	}
 
 	vaultCfg := vault.Config{Address: config.Endpoint}
-	if err := vaultCfg.ConfigureTLS(&vault.TLSConfig{CAPath: config.CAPath}); err != nil {
+	tlsConfig := &vault.TLSConfig{CAPath: config.CAPath}
+	if config.Auth.TLS.CertFile != "" || config.Auth.TLS.KeyFile != "" {
+		tlsConfig.ClientCert = config.Auth.TLS.CertFile
+		tlsConfig.ClientKey = config.Auth.TLS.KeyFile
+	}
+	if err := vaultCfg.ConfigureTLS(tlsConfig); err != nil {
 		return nil, err
 	}
 	client, err := vault.NewClient(&vaultCfg)
@@ -122,87 +230,116 @@ func NewVault(config VaultConfig) (KMS, error) {
 	if config.Namespace != "" {
 		client.SetNamespace(config.Namespace)
 	}
-	v := &vaultService{client: client, config: &config}
+	if config.TransitMount == "" {
+		config.TransitMount = "transit"
+	}
+	auth, err := newVaultAuthMethod(config.Auth)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v := &vaultService{client: client, config: &config, auth: auth, ctx: ctx, cancel: cancel}
 
 	if err := v.authenticate(); err != nil {
+		cancel()
 		return nil, err
 	}
+	go v.sweepKeyVersions()
 	return v, nil
 }
 
-// renewSecret tries to renew the given secret. It blocks
-// until it receives either the new secret or encounters an error.
-func (v *vaultService) renewSecret(secret *vault.Secret) (*vault.Secret, error) {
-	renewer, err := v.client.NewRenewer(&vault.RenewerInput{
-		Secret: secret,
+// watchLifetime drives a vault.LifetimeWatcher for the given secret
+// until it either stops cleanly (v.ctx canceled) or the watcher gives
+// up, in which case it returns the error it gave up with.
+func (v *vaultService) watchLifetime(secret *vault.Secret) error {
+	watcher, err := v.client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{
+		Secret:        secret,
+		RenewBehavior: vault.RenewBehaviorErrorOnErrors,
 	})
 	if err != nil {
-		logger.CriticalIf(context.Background(), fmt.Errorf("crypto: failed to create hashicorp vault renewer: %s", err))
+		return fmt.Errorf("crypto: failed to create hashicorp vault lifetime watcher: %v", err)
 	}
-	go renewer.Renew()
-	defer renewer.Stop()
+	go watcher.Start()
+	defer watcher.Stop()
 
 	for {
 		select {
-		case err := <-renewer.DoneCh():
-			if err != nil {
-				return nil, err
-			}
-		case renew := <-renewer.RenewCh():
+		case <-v.ctx.Done():
+			return nil
+		case err := <-watcher.DoneCh():
+			return err
+		case renew := <-watcher.RenewCh():
 			if renew.Secret == nil || renew.Secret.Auth == nil {
-				return nil, ErrKMSAuthLogin
+				return ErrKMSAuthLogin
 			}
-			return renew.Secret, nil
+			v.setStatus(true, time.Duration(renew.Secret.Auth.LeaseDuration)*time.Second)
 		}
 	}
 }
 
-// login tries to authenticate the minio server to
-// the Vault KMS using the approle ID and secret.
+// login tries to authenticate the minio server to the Vault KMS
+// using the configured VaultAuthMethod.
 func (v *vaultService) login() (*vault.Secret, error) {
-	payload := map[string]interface{}{
-		"role_id":   v.config.Auth.AppRole.ID,
-		"secret_id": v.config.Auth.AppRole.Secret,
-	}
-	secret, err := v.client.Logical().Write("auth/approle/login", payload)
-	if err != nil {
-		return nil, err
-	}
-	if secret == nil || secret.Auth == nil {
-		return nil, ErrKMSAuthLogin
-	}
-	return secret, nil
+	return v.auth.Login(v.client)
 }
 
 // authenticate tries to authenticate the minio server
-// to the Vault KMS and starts a background job to renew
-// the login.
+// to the Vault KMS and starts a background job to keep the
+// session alive - unless the configured auth method is not
+// renewable, e.g. a plain pre-issued token.
 func (v *vaultService) authenticate() error {
 	secret, err := v.login()
 	if err != nil {
 		return err
 	}
 	v.client.SetToken(secret.Auth.ClientToken)
-	v.leaseDuration = time.Duration(secret.Auth.LeaseDuration)
+	v.setStatus(true, time.Duration(secret.Auth.LeaseDuration)*time.Second)
+
+	if !v.auth.Renewable() {
+		return nil
+	}
+
+	go v.keepAlive(secret)
+	return nil
+}
+
+// keepAlive watches the current secret's lifetime and re-logs-in
+// whenever the watcher gives up, backing off exponentially between
+// failed attempts so a misconfigured or unreachable Vault does not
+// spin the server. It runs until v.ctx is canceled.
+func (v *vaultService) keepAlive(secret *vault.Secret) {
+	backoff := newBackoff(time.Second, 5*time.Minute)
+	for {
+		if err := v.watchLifetime(secret); err != nil {
+			v.setStatus(false, 0)
+			logger.LogIf(v.ctx, fmt.Errorf("crypto: hashicorp vault lifetime watcher stopped: %v", err))
+		}
+		if v.ctx.Err() != nil {
+			return
+		}
 
-	// Start background job trying to renew the token
-	// or (if this fails) try to login again with app-ID and app-Secret.
-	go func(secret *vault.Secret) {
+		// The watcher stopped - either it errored out or the secret's
+		// lease simply expired. Try to login again, backing off
+		// between failed attempts.
 		for {
-			newSecret, err := v.renewSecret(secret) // try to renew the secret (blocking)
-			if err != nil {
-				// Try to login again with app-ID and app-Secret
-				if newSecret, err = v.login(); err != nil { // failed -> try again
-					time.Sleep(1 * time.Minute) // retry delay
-					continue
-				}
+			newSecret, err := v.login()
+			if err == nil {
+				secret = newSecret
+				v.client.SetToken(secret.Auth.ClientToken)
+				v.setStatus(true, time.Duration(secret.Auth.LeaseDuration)*time.Second)
+				backoff.reset()
+				break
+			}
+			v.setStatus(false, 0)
+			logger.LogIf(v.ctx, fmt.Errorf("crypto: failed to re-authenticate to hashicorp vault: %v", err))
+
+			select {
+			case <-v.ctx.Done():
+				return
+			case <-time.After(backoff.next()):
 			}
-			secret = newSecret // Now newSecret contains a valid, non-nil *vault.Secret
-			v.client.SetToken(secret.Auth.ClientToken)
-			v.leaseDuration = time.Duration(secret.Auth.LeaseDuration)
 		}
-	}(secret)
-	return nil
+	}
 }
 
 // GenerateKey returns a new plaintext key, generated by the KMS,
@@ -216,7 +353,10 @@ func (v *vaultService) GenerateKey(keyID string, ctx Context) (key [32]byte, sea
 	payload := map[string]interface{}{
 		"context": base64.StdEncoding.EncodeToString(contextStream.Bytes()),
 	}
-	s, err := v.client.Logical().Write(fmt.Sprintf("/transit/datakey/plaintext/%s", keyID), payload)
+	if v.config.Key.Version > 0 {
+		payload["key_version"] = v.config.Key.Version
+	}
+	s, err := v.client.Logical().Write(fmt.Sprintf("/%s/datakey/plaintext/%s", v.config.TransitMount, keyID), payload)
 	if err != nil {
 		return key, sealedKey, err
 	}
@@ -244,7 +384,7 @@ func (v *vaultService) UnsealKey(keyID string, sealedKey []byte, ctx Context) (k
 		"ciphertext": string(sealedKey),
 		"context":    base64.StdEncoding.EncodeToString(contextStream.Bytes()),
 	}
-	s, err := v.client.Logical().Write(fmt.Sprintf("/transit/decrypt/%s", keyID), payload)
+	s, err := v.client.Logical().Write(fmt.Sprintf("/%s/decrypt/%s", v.config.TransitMount, keyID), payload)
 	if err != nil {
 		return key, err
 	}
@@ -256,3 +396,183 @@ func (v *vaultService) UnsealKey(keyID string, sealedKey []byte, ctx Context) (k
 	copy(key[:], []byte(plainKey))
 	return key, nil
 }
+
+// SealKey seals an already-generated plaintext data-key via Vault's
+// transit/encrypt endpoint. Unlike GenerateKey it never asks Vault to
+// produce the plaintext itself - it is used to move an existing
+// data-key to Vault from a different KMS backend during a migration,
+// without having to re-encrypt the object the key protects.
+func (v *vaultService) SealKey(keyID string, key [32]byte, ctx Context) (sealedKey []byte, err error) {
+	var contextStream bytes.Buffer
+	ctx.WriteTo(&contextStream)
+
+	payload := map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(key[:]),
+		"context":   base64.StdEncoding.EncodeToString(contextStream.Bytes()),
+	}
+	s, err := v.client.Logical().Write(fmt.Sprintf("/%s/encrypt/%s", v.config.TransitMount, keyID), payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s.Data["ciphertext"].(string)), nil
+}
+
+var _ KeySealer = (*vaultService)(nil) // compiler check that *vaultService implements KeySealer
+
+// RewrapKey re-encrypts sealedKey under the transit key's current
+// (latest) version via Vault's transit/rewrap endpoint. Vault never
+// exposes the plaintext data-encryption-key while doing so.
+//
+// The context must be the same context that was used to generate the
+// sealedKey.
+func (v *vaultService) RewrapKey(keyID string, sealedKey []byte, ctx Context) ([]byte, error) {
+	var contextStream bytes.Buffer
+	ctx.WriteTo(&contextStream)
+
+	payload := map[string]interface{}{
+		"ciphertext": string(sealedKey),
+		"context":    base64.StdEncoding.EncodeToString(contextStream.Bytes()),
+	}
+	s, err := v.client.Logical().Write(fmt.Sprintf("/%s/rewrap/%s", v.config.TransitMount, keyID), payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s.Data["ciphertext"].(string)), nil
+}
+
+// NeedsRewrap reports whether sealedKey was sealed under a transit
+// key version older than the key's current minimum decryption
+// version and should therefore be rewrapped. It relies on the
+// background key-version sweeper, so the very first calls after
+// start-up may under-report until the sweeper has run once.
+func (v *vaultService) NeedsRewrap(keyID string, sealedKey []byte) (bool, error) {
+	version, err := vaultCiphertextVersion(sealedKey)
+	if err != nil {
+		return false, err
+	}
+	v.keyVersionsMu.RLock()
+	info, ok := v.keyVersions[keyID]
+	v.keyVersionsMu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return version < info.MinDecryptionVersion, nil
+}
+
+var _ RewrapChecker = (*vaultService)(nil) // compiler check that *vaultService implements RewrapChecker
+
+// vaultKeyVersion holds the latest and minimum-decryption transit key
+// versions last observed for a given key name.
+type vaultKeyVersion struct {
+	LatestVersion        int
+	MinDecryptionVersion int
+}
+
+// vaultCiphertextVersion extracts the key version a Vault transit
+// ciphertext was sealed under, e.g. "vault:v3:..." -> 3.
+func vaultCiphertextVersion(sealedKey []byte) (int, error) {
+	parts := strings.SplitN(string(sealedKey), ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, errors.New("crypto: invalid hashicorp vault ciphertext format")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil {
+		return 0, fmt.Errorf("crypto: invalid hashicorp vault ciphertext version: %v", err)
+	}
+	return version, nil
+}
+
+// backoff computes capped exponential backoff durations with jitter,
+// used to pace retries of renew/re-login attempts against Vault.
+type backoff struct {
+	base, max time.Duration
+	attempt   uint
+}
+
+// newBackoff returns a backoff that starts at base and doubles on
+// every call to next() until it reaches max.
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max}
+}
+
+// next returns the next backoff duration and advances the sequence.
+// Each duration is jittered by up to ±20% so that many servers
+// retrying at once do not stay in lock-step.
+func (b *backoff) next() time.Duration {
+	d := b.base << b.attempt
+	if d <= 0 || d > b.max { // overflow or past the cap
+		d = b.max
+	} else {
+		b.attempt++
+	}
+
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(d)) // ±20%
+	return d + jitter
+}
+
+// reset restarts the sequence at base, e.g. after a successful retry.
+func (b *backoff) reset() { b.attempt = 0 }
+
+// rewrapSweepInterval is how often the background sweeper refreshes
+// the transit key's latest/min-decryption version.
+const rewrapSweepInterval = 5 * time.Minute
+
+// sweepKeyVersions periodically queries transit/keys/<name> for the
+// configured key's latest_version and min_decryption_version so that
+// NeedsRewrap can answer without a Vault round-trip on every object
+// access. It runs until v.ctx is canceled.
+func (v *vaultService) sweepKeyVersions() {
+	ticker := time.NewTicker(rewrapSweepInterval)
+	defer ticker.Stop()
+
+	v.refreshKeyVersion(v.config.Key.Name)
+	for {
+		select {
+		case <-v.ctx.Done():
+			return
+		case <-ticker.C:
+			v.refreshKeyVersion(v.config.Key.Name)
+		}
+	}
+}
+
+// refreshKeyVersion fetches the current latest/min-decryption version
+// of keyID from Vault and stores it for NeedsRewrap to consult.
+func (v *vaultService) refreshKeyVersion(keyID string) {
+	s, err := v.client.Logical().Read(fmt.Sprintf("/%s/keys/%s", v.config.TransitMount, keyID))
+	if err != nil || s == nil {
+		return
+	}
+	latest, _ := s.Data["latest_version"].(json.Number)
+	minDecryption, _ := s.Data["min_decryption_version"].(json.Number)
+	latestVersion, _ := latest.Int64()
+	minDecryptionVersion, _ := minDecryption.Int64()
+	newVersion := vaultKeyVersion{
+		LatestVersion:        int(latestVersion),
+		MinDecryptionVersion: int(minDecryptionVersion),
+	}
+
+	v.keyVersionsMu.Lock()
+	if v.keyVersions == nil {
+		v.keyVersions = map[string]vaultKeyVersion{}
+	}
+	oldVersion, known := v.keyVersions[keyID]
+	v.keyVersions[keyID] = newVersion
+	v.keyVersionsMu.Unlock()
+
+	if known && oldVersion.LatestVersion != newVersion.LatestVersion {
+		v.notifyKeyRotated(keyID)
+	}
+}
+
+// notifyKeyRotated invokes every callback registered via
+// OnKeyRotated for keyID.
+func (v *vaultService) notifyKeyRotated(keyID string) {
+	v.rotationMu.Lock()
+	callbacks := append([]func(string){}, v.onRotated...)
+	v.rotationMu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(keyID)
+	}
+}
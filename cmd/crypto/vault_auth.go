@@ -0,0 +1,302 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// kubernetesServiceAccountTokenPath is the well-known location of the
+// service-account JWT that is automatically mounted into every pod
+// running in a Kubernetes cluster.
+const kubernetesServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultAuthMethod is implemented by every supported Vault
+// authentication mechanism. Login performs the actual login request
+// against the Vault server referenced by client and returns the
+// resulting secret - which must contain valid auth information.
+// Path returns the Vault login path used, mostly useful for logging
+// and error messages.
+type VaultAuthMethod interface {
+	// Login authenticates to Vault and returns the resulting secret.
+	Login(client *vault.Client) (*vault.Secret, error)
+
+	// Path returns the Vault API path this method logs in against,
+	// e.g. "auth/approle/login".
+	Path() string
+
+	// Renewable reports whether the resulting token should be renewed
+	// and re-logged-in on expiry. Methods like the plain token mode
+	// return false because the token is managed outside of Minio.
+	Renewable() bool
+}
+
+// newVaultAuthMethod returns the VaultAuthMethod described by auth,
+// or an error if auth does not describe a supported or well-formed
+// authentication method.
+func newVaultAuthMethod(auth VaultAuth) (VaultAuthMethod, error) {
+	switch strings.ToLower(auth.Type) {
+	case "approle":
+		if auth.AppRole.ID == "" {
+			return nil, errors.New("crypto: missing hashicorp vault AppRole ID")
+		}
+		if auth.AppRole.Secret == "" {
+			return nil, errors.New("crypto: missing hashicorp vault AppRole secret ID")
+		}
+		return &vaultAppRoleAuth{AppRole: auth.AppRole}, nil
+	case "kubernetes":
+		if auth.Kubernetes.Role == "" {
+			return nil, errors.New("crypto: missing hashicorp vault kubernetes role")
+		}
+		mount := auth.Kubernetes.Mount
+		if mount == "" {
+			mount = "kubernetes"
+		}
+		path := auth.Kubernetes.JWTPath
+		if path == "" {
+			path = kubernetesServiceAccountTokenPath
+		}
+		return &vaultKubernetesAuth{Mount: mount, Role: auth.Kubernetes.Role, JWTPath: path}, nil
+	case "jwt", "oidc":
+		if auth.JWT.Role == "" {
+			return nil, errors.New("crypto: missing hashicorp vault JWT role")
+		}
+		mount := auth.JWT.Mount
+		if mount == "" {
+			mount = "jwt"
+		}
+		return &vaultJWTAuth{Mount: mount, Role: auth.JWT.Role, JWTPath: auth.JWT.JWTPath, JWT: auth.JWT.JWT}, nil
+	case "cert", "tls":
+		if auth.TLS.CertFile == "" || auth.TLS.KeyFile == "" {
+			return nil, errors.New("crypto: missing hashicorp vault client certificate or key for cert auth")
+		}
+		return &vaultTLSCertAuth{Name: auth.TLS.Name}, nil
+	case "aws", "aws-iam":
+		if auth.AWS.Role == "" {
+			return nil, errors.New("crypto: missing hashicorp vault AWS IAM role")
+		}
+		mount := auth.AWS.Mount
+		if mount == "" {
+			mount = "aws"
+		}
+		return &vaultAWSIAMAuth{Mount: mount, Role: auth.AWS.Role}, nil
+	case "token":
+		if auth.Token == "" && auth.TokenFile == "" {
+			return nil, errors.New("crypto: missing hashicorp vault token or token file")
+		}
+		return &vaultTokenAuth{Token: auth.Token, TokenFile: auth.TokenFile}, nil
+	default:
+		return nil, fmt.Errorf("crypto: invalid hashicorp vault authentication type: %s is not supported", auth.Type)
+	}
+}
+
+// vaultAppRoleAuth implements the original AppRole authentication
+// method - the only method Minio supported before pluggable auth was
+// introduced.
+type vaultAppRoleAuth struct {
+	AppRole VaultAppRole
+}
+
+func (a *vaultAppRoleAuth) Path() string    { return "auth/approle/login" }
+func (a *vaultAppRoleAuth) Renewable() bool { return true }
+
+func (a *vaultAppRoleAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	payload := map[string]interface{}{
+		"role_id":   a.AppRole.ID,
+		"secret_id": a.AppRole.Secret,
+	}
+	secret, err := client.Logical().Write(a.Path(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, ErrKMSAuthLogin
+	}
+	return secret, nil
+}
+
+// vaultKubernetesAuth authenticates using the projected service
+// account JWT of the pod Minio is running in, against Vault's
+// kubernetes auth method.
+type vaultKubernetesAuth struct {
+	Mount   string
+	Role    string
+	JWTPath string
+}
+
+func (a *vaultKubernetesAuth) Path() string    { return fmt.Sprintf("auth/%s/login", a.Mount) }
+func (a *vaultKubernetesAuth) Renewable() bool { return true }
+
+func (a *vaultKubernetesAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	jwt, err := ioutil.ReadFile(a.JWTPath)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: failed to read kubernetes service account token: %v", err)
+	}
+	payload := map[string]interface{}{
+		"role": a.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+	secret, err := client.Logical().Write(a.Path(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, ErrKMSAuthLogin
+	}
+	return secret, nil
+}
+
+// vaultJWTAuth authenticates against Vault's generic JWT/OIDC auth
+// method. JWT is either set directly or read from JWTPath on every
+// login attempt - e.g. when a workload identity token is periodically
+// refreshed onto disk.
+type vaultJWTAuth struct {
+	Mount   string
+	Role    string
+	JWTPath string
+	JWT     string
+}
+
+func (a *vaultJWTAuth) Path() string    { return fmt.Sprintf("auth/%s/login", a.Mount) }
+func (a *vaultJWTAuth) Renewable() bool { return true }
+
+func (a *vaultJWTAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	token := a.JWT
+	if a.JWTPath != "" {
+		raw, err := ioutil.ReadFile(a.JWTPath)
+		if err != nil {
+			return nil, fmt.Errorf("crypto: failed to read JWT token file: %v", err)
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	if token == "" {
+		return nil, errors.New("crypto: no JWT token available for hashicorp vault JWT auth")
+	}
+	payload := map[string]interface{}{
+		"role": a.Role,
+		"jwt":  token,
+	}
+	secret, err := client.Logical().Write(a.Path(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, ErrKMSAuthLogin
+	}
+	return secret, nil
+}
+
+// vaultTLSCertAuth authenticates using the mTLS client certificate
+// already configured on the vault.Client's HTTP transport against
+// Vault's cert auth method.
+type vaultTLSCertAuth struct {
+	// Name is the name of the certificate role configured in Vault.
+	// It is optional - Vault will match the cert against all roles
+	// if it is empty.
+	Name string
+}
+
+func (a *vaultTLSCertAuth) Path() string    { return "auth/cert/login" }
+func (a *vaultTLSCertAuth) Renewable() bool { return true }
+
+func (a *vaultTLSCertAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	payload := map[string]interface{}{}
+	if a.Name != "" {
+		payload["name"] = a.Name
+	}
+	secret, err := client.Logical().Write(a.Path(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, ErrKMSAuthLogin
+	}
+	return secret, nil
+}
+
+// vaultAWSIAMAuth authenticates against Vault's aws auth method using
+// the iam login type: it signs a sts:GetCallerIdentity request with
+// the node's AWS credentials and forwards the signed request to
+// Vault, which verifies it against STS.
+type vaultAWSIAMAuth struct {
+	Mount string
+	Role  string
+}
+
+func (a *vaultAWSIAMAuth) Path() string    { return fmt.Sprintf("auth/%s/login", a.Mount) }
+func (a *vaultAWSIAMAuth) Renewable() bool { return true }
+
+func (a *vaultAWSIAMAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	payload, err := awsIAMLoginPayload(a.Role)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: failed to build AWS IAM login request: %v", err)
+	}
+	secret, err := client.Logical().Write(a.Path(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, ErrKMSAuthLogin
+	}
+	return secret, nil
+}
+
+// vaultTokenAuth uses a pre-issued Vault token directly, without ever
+// logging in. It is not renewable - operators are expected to supply
+// a token whose lifetime outlives the server, or to rotate it
+// out-of-band. If TokenFile is set, the token is re-read from disk on
+// every login attempt instead of being fixed at config time, the same
+// way vaultJWTAuth's JWTPath is - useful when the token is periodically
+// rotated onto disk by an external agent.
+type vaultTokenAuth struct {
+	Token     string
+	TokenFile string
+}
+
+func (a *vaultTokenAuth) Path() string    { return "" }
+func (a *vaultTokenAuth) Renewable() bool { return false }
+
+func (a *vaultTokenAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	token := a.Token
+	if a.TokenFile != "" {
+		raw, err := ioutil.ReadFile(a.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("crypto: failed to read vault token file: %v", err)
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	if token == "" {
+		return nil, errors.New("crypto: no hashicorp vault token available")
+	}
+
+	client.SetToken(token)
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, ErrKMSAuthLogin
+	}
+	// LookupSelf does not return a secret with Auth info - synthesize
+	// one so the rest of vaultService can treat every auth method
+	// uniformly.
+	secret.Auth = &vault.SecretAuth{ClientToken: token, Renewable: false}
+	return secret, nil
+}
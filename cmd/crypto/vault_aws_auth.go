@@ -0,0 +1,76 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// stsRequestURL is the global STS endpoint Vault's aws auth method
+// expects the signed sts:GetCallerIdentity request to target.
+const stsRequestURL = "https://sts.amazonaws.com/"
+
+// awsIAMLoginPayload builds the login payload Vault's aws auth method
+// (iam login type) expects: a pre-signed sts:GetCallerIdentity
+// request, split into its method/url/body/headers so Vault can replay
+// it against STS and verify the caller's identity without Minio ever
+// sharing long-term AWS credentials with Vault. Credentials are
+// resolved the same way the AWS SDK resolves them by default - env
+// vars, the shared credentials file, then the EC2/ECS instance role.
+func awsIAMLoginPayload(role string) (map[string]interface{}, error) {
+	creds := defaults.CredChain(defaults.Config(), defaults.Handlers())
+
+	body := strings.NewReader("Action=GetCallerIdentity&Version=2011-06-15")
+	req, err := http.NewRequest(http.MethodPost, stsRequestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	signer := v4.NewSigner(creds)
+	if _, err = signer.Sign(req, body, "sts", "us-east-1", time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string][]string, len(req.Header))
+	for k, v := range req.Header {
+		headers[k] = v
+	}
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+	rawBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{
+		"role":                    role,
+		"iam_http_request_method": req.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.URL.String())),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(rawBody),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headersJSON),
+	}
+	return payload, nil
+}
@@ -0,0 +1,41 @@
+// Minio Cloud Storage, (C) 2015, 2016, 2017, 2018 Minio, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import "testing"
+
+func TestVaultCiphertextVersion(t *testing.T) {
+	cases := []struct {
+		sealedKey string
+		version   int
+		wantErr   bool
+	}{
+		{"vault:v1:abcd", 1, false},
+		{"vault:v42:abcd", 42, false},
+		{"not-a-vault-ciphertext", 0, true},
+		{"vault:nope:abcd", 0, true},
+		{"vault:v1", 0, true},
+	}
+	for _, c := range cases {
+		version, err := vaultCiphertextVersion([]byte(c.sealedKey))
+		if (err != nil) != c.wantErr {
+			t.Errorf("%q: err = %v, wantErr = %v", c.sealedKey, err, c.wantErr)
+			continue
+		}
+		if err == nil && version != c.version {
+			t.Errorf("%q: version = %d, want %d", c.sealedKey, version, c.version)
+		}
+	}
+}